@@ -0,0 +1,226 @@
+// Copyright 2012 Brian "bojo" Jones. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package redistore
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+// CompressionAlgo identifies the compression codec layered on top of a
+// SessionSerializer's output.
+type CompressionAlgo byte
+
+const (
+	// CompressionNone stores the serialized payload as-is.
+	CompressionNone CompressionAlgo = 0
+	// CompressionGzip compresses the serialized payload with gzip.
+	CompressionGzip CompressionAlgo = 1
+	// CompressionSnappy compresses the serialized payload with snappy.
+	CompressionSnappy CompressionAlgo = 2
+	// CompressionZstd compresses the serialized payload with zstd.
+	CompressionZstd CompressionAlgo = 3
+)
+
+// frameVersion identifies the 1-byte header format written by encodePayload.
+// Payloads written before SetCompression/SetEncryption existed have no such
+// header; decodePayload tells the two apart by the header's high nibble so
+// load() stays compatible with data saved by older versions of this package.
+const frameVersion byte = 1
+
+const flagEncrypted byte = 0x08
+
+// SetCompression enables compression of session payloads once their
+// serialized size exceeds minSize. algo selects the codec; pass
+// CompressionNone to disable compression again. Payloads at or below minSize
+// are stored uncompressed, still behind the 1-byte frame header, to avoid
+// paying the compression overhead for small sessions.
+func (s *RediStore) SetCompression(algo CompressionAlgo, minSize int) {
+	s.compressionAlgo = algo
+	s.compressionMinSize = minSize
+}
+
+// SetEncryption enables or disables AES-GCM at-rest encryption of session
+// payloads, using a key derived from the first securecookie key pair the
+// store was constructed with.
+func (s *RediStore) SetEncryption(enabled bool) {
+	s.encrypt = enabled
+}
+
+// encodePayload applies the configured compression and encryption to a
+// serialized session payload before it is written to redis. It writes a
+// 1-byte header (version|algo|encrypted flag) so decodePayload can reverse
+// the transformation, unless neither compression nor encryption is
+// configured, in which case b is returned unchanged for full backward
+// compatibility with existing uncompressed JSON/gob blobs.
+func (s *RediStore) encodePayload(b []byte) ([]byte, error) {
+	if s.compressionAlgo == CompressionNone && !s.encrypt {
+		return b, nil
+	}
+
+	algo := CompressionNone
+	payload := b
+	if s.compressionAlgo != CompressionNone && len(b) > s.compressionMinSize {
+		compressed, err := compress(s.compressionAlgo, b)
+		if err != nil {
+			return nil, err
+		}
+		payload = compressed
+		algo = s.compressionAlgo
+	}
+
+	var flags byte
+	if s.encrypt {
+		key, err := s.encryptionKey()
+		if err != nil {
+			return nil, err
+		}
+		encrypted, err := encryptGCM(key, payload)
+		if err != nil {
+			return nil, err
+		}
+		payload = encrypted
+		flags |= flagEncrypted
+	}
+
+	framed := make([]byte, 0, len(payload)+1)
+	framed = append(framed, frameVersion<<4|byte(algo)|flags)
+	return append(framed, payload...), nil
+}
+
+// decodePayload reverses encodePayload. Data without a recognized frame
+// header is assumed to be a legacy uncompressed, unencrypted blob and is
+// returned unchanged.
+func (s *RediStore) decodePayload(b []byte) ([]byte, error) {
+	if len(b) == 0 || b[0]>>4 != frameVersion {
+		return b, nil
+	}
+	algo := CompressionAlgo(b[0] & 0x07)
+	encrypted := b[0]&flagEncrypted != 0
+	payload := b[1:]
+
+	if encrypted {
+		key, err := s.encryptionKey()
+		if err != nil {
+			return nil, err
+		}
+		decrypted, err := decryptGCM(key, payload)
+		if err != nil {
+			return nil, err
+		}
+		payload = decrypted
+	}
+
+	if algo != CompressionNone {
+		decompressed, err := decompress(algo, payload)
+		if err != nil {
+			return nil, err
+		}
+		payload = decompressed
+	}
+
+	return payload, nil
+}
+
+// encryptionKey derives a 32 byte AES-256 key from the first securecookie
+// key pair the store was constructed with.
+func (s *RediStore) encryptionKey() ([]byte, error) {
+	if len(s.keyPairs) == 0 || len(s.keyPairs[0]) == 0 {
+		return nil, errors.New("redistore: encryption enabled but no securecookie key pair is available to derive a key from")
+	}
+	key := sha256.Sum256(s.keyPairs[0])
+	return key[:], nil
+}
+
+func compress(algo CompressionAlgo, b []byte) ([]byte, error) {
+	switch algo {
+	case CompressionGzip:
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		if _, err := w.Write(b); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case CompressionSnappy:
+		return snappy.Encode(nil, b), nil
+	case CompressionZstd:
+		enc, err := zstd.NewWriter(nil)
+		if err != nil {
+			return nil, err
+		}
+		defer enc.Close()
+		return enc.EncodeAll(b, nil), nil
+	default:
+		return nil, fmt.Errorf("redistore: unsupported compression algorithm %d", algo)
+	}
+}
+
+func decompress(algo CompressionAlgo, b []byte) ([]byte, error) {
+	switch algo {
+	case CompressionGzip:
+		r, err := gzip.NewReader(bytes.NewReader(b))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		return io.ReadAll(r)
+	case CompressionSnappy:
+		return snappy.Decode(nil, b)
+	case CompressionZstd:
+		dec, err := zstd.NewReader(nil)
+		if err != nil {
+			return nil, err
+		}
+		defer dec.Close()
+		return dec.DecodeAll(b, nil)
+	default:
+		return nil, fmt.Errorf("redistore: unsupported compression algorithm %d", algo)
+	}
+}
+
+func encryptGCM(key, plaintext []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func decryptGCM(key, data []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < gcm.NonceSize() {
+		return nil, errors.New("redistore: encrypted payload is shorter than the GCM nonce")
+	}
+	nonce, ciphertext := data[:gcm.NonceSize()], data[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, ciphertext, nil)
+}
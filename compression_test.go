@@ -0,0 +1,122 @@
+// Copyright 2012 Brian "bojo" Jones. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package redistore
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestEncodeDecodePayloadRoundTrip(t *testing.T) {
+	payload := bytes.Repeat([]byte("the quick brown fox jumps over the lazy dog "), 50)
+
+	cases := []struct {
+		name    string
+		algo    CompressionAlgo
+		minSize int
+		encrypt bool
+	}{
+		{name: "plain"},
+		{name: "gzip", algo: CompressionGzip},
+		{name: "snappy", algo: CompressionSnappy},
+		{name: "zstd", algo: CompressionZstd},
+		{name: "encrypted", encrypt: true},
+		{name: "gzip+encrypted", algo: CompressionGzip, encrypt: true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := &RediStore{
+				keyPairs:           [][]byte{[]byte("test-secret-key")},
+				compressionAlgo:    tc.algo,
+				compressionMinSize: tc.minSize,
+				encrypt:            tc.encrypt,
+			}
+
+			encoded, err := s.encodePayload(payload)
+			if err != nil {
+				t.Fatalf("encodePayload: %v", err)
+			}
+
+			if tc.algo != CompressionNone && len(encoded) >= len(payload) {
+				t.Errorf("expected compressed payload to be smaller, got %d >= %d", len(encoded), len(payload))
+			}
+
+			decoded, err := s.decodePayload(encoded)
+			if err != nil {
+				t.Fatalf("decodePayload: %v", err)
+			}
+			if !bytes.Equal(decoded, payload) {
+				t.Errorf("round trip mismatch: got %q, want %q", decoded, payload)
+			}
+		})
+	}
+}
+
+func TestEncodePayloadNoopWhenUnconfigured(t *testing.T) {
+	s := &RediStore{}
+	payload := []byte(`{"foo":"bar"}`)
+
+	encoded, err := s.encodePayload(payload)
+	if err != nil {
+		t.Fatalf("encodePayload: %v", err)
+	}
+	if !bytes.Equal(encoded, payload) {
+		t.Errorf("expected unconfigured store to leave payload untouched, got %q, want %q", encoded, payload)
+	}
+}
+
+func TestDecodePayloadLegacyUnframedPassthrough(t *testing.T) {
+	s := &RediStore{
+		keyPairs:        [][]byte{[]byte("test-secret-key")},
+		compressionAlgo: CompressionGzip,
+		encrypt:         true,
+	}
+
+	// Data saved before compression/encryption existed has no frame header
+	// and must be returned unchanged, not mistaken for framed data.
+	legacy := []byte(`{"created_time":"20060102150405"}`)
+
+	decoded, err := s.decodePayload(legacy)
+	if err != nil {
+		t.Fatalf("decodePayload: %v", err)
+	}
+	if !bytes.Equal(decoded, legacy) {
+		t.Errorf("expected legacy payload to pass through unchanged, got %q, want %q", decoded, legacy)
+	}
+}
+
+func TestEncodePayloadSkipsCompressionBelowMinSize(t *testing.T) {
+	s := &RediStore{
+		compressionAlgo:    CompressionGzip,
+		compressionMinSize: 1024,
+	}
+	payload := []byte("short")
+
+	encoded, err := s.encodePayload(payload)
+	if err != nil {
+		t.Fatalf("encodePayload: %v", err)
+	}
+	decoded, err := s.decodePayload(encoded)
+	if err != nil {
+		t.Fatalf("decodePayload: %v", err)
+	}
+	if !bytes.Equal(decoded, payload) {
+		t.Errorf("round trip mismatch: got %q, want %q", decoded, payload)
+	}
+}
+
+func TestDecodePayloadEncryptedWithoutKeyFails(t *testing.T) {
+	s := &RediStore{keyPairs: [][]byte{[]byte("test-secret-key")}, encrypt: true}
+	encoded, err := s.encodePayload([]byte("secret"))
+	if err != nil {
+		t.Fatalf("encodePayload: %v", err)
+	}
+
+	noKey := &RediStore{encrypt: true}
+	if _, err := noKey.decodePayload(encoded); err == nil {
+		t.Error("expected an error decoding an encrypted payload with no key pairs configured")
+	}
+}
@@ -0,0 +1,69 @@
+package redistore
+
+import "testing"
+
+func TestDefaultKeyGenReturnsDistinctURLSafeValues(t *testing.T) {
+	a, err := DefaultKeyGen()
+	if err != nil {
+		t.Fatalf("DefaultKeyGen: %v", err)
+	}
+	b, err := DefaultKeyGen()
+	if err != nil {
+		t.Fatalf("DefaultKeyGen: %v", err)
+	}
+	if a == b {
+		t.Error("expected two calls to DefaultKeyGen to return distinct values")
+	}
+	if a == "" {
+		t.Error("expected a non-empty key")
+	}
+	for _, c := range a {
+		if c == '+' || c == '/' || c == '=' {
+			t.Errorf("expected URL-safe, unpadded output, got %q", a)
+			break
+		}
+	}
+}
+
+func TestUUIDKeyGenReturnsDistinctUUIDs(t *testing.T) {
+	a, err := UUIDKeyGen()
+	if err != nil {
+		t.Fatalf("UUIDKeyGen: %v", err)
+	}
+	b, err := UUIDKeyGen()
+	if err != nil {
+		t.Fatalf("UUIDKeyGen: %v", err)
+	}
+	if a == b {
+		t.Error("expected two calls to UUIDKeyGen to return distinct values")
+	}
+	if len(a) != 36 {
+		t.Errorf("expected a 36 character UUID string, got %q (len %d)", a, len(a))
+	}
+}
+
+func TestURLSafeKeyGenReturnsDistinctValuesOfRequestedLength(t *testing.T) {
+	gen := URLSafeKeyGen(16)
+	a, err := gen()
+	if err != nil {
+		t.Fatalf("URLSafeKeyGen(16)(): %v", err)
+	}
+	b, err := gen()
+	if err != nil {
+		t.Fatalf("URLSafeKeyGen(16)(): %v", err)
+	}
+	if a == b {
+		t.Error("expected two calls to the generated KeyGenFunc to return distinct values")
+	}
+	// 16 random bytes encode to 22 unpadded base64 characters.
+	if len(a) != 22 {
+		t.Errorf("expected a 22 character key for 16 random bytes, got %q (len %d)", a, len(a))
+	}
+
+	for _, c := range a {
+		if c == '+' || c == '/' || c == '=' {
+			t.Errorf("expected URL-safe, unpadded output, got %q", a)
+			break
+		}
+	}
+}
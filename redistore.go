@@ -6,18 +6,23 @@ package redistore
 
 import (
 	"bytes"
+	"context"
 	"encoding/base32"
+	"encoding/base64"
 	"encoding/gob"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
-	"github.com/go-redis/redis"
+	"github.com/google/uuid"
 	"github.com/gorilla/securecookie"
 	"github.com/gorilla/sessions"
+	"github.com/redis/go-redis/v9"
 )
 
 // Amount of time for cookies/redis keys to expire.
@@ -93,13 +98,20 @@ func (s GobSerializer) Deserialize(d []byte, ss *sessions.Session) error {
 
 // RediStore stores sessions in a redis backend.
 type RediStore struct {
-	Pool          redis.Cmdable
+	Pool          redis.UniversalClient
 	Codecs        []securecookie.Codec
 	Options       *sessions.Options // default configuration
 	DefaultMaxAge int               // default Redis TTL for a MaxAge == 0 session
+	KeyGen        KeyGenFunc        // generates the session ID used as the redis key
 	maxLength     int
 	keyPrefix     string
 	serializer    SessionSerializer
+	userIDKey     string // session.Values key holding the user identifier, if any
+
+	compressionAlgo    CompressionAlgo
+	compressionMinSize int
+	encrypt            bool
+	keyPairs           [][]byte // raw securecookie key pairs, kept to derive the encryption key
 }
 
 type SessionInfo struct {
@@ -107,6 +119,40 @@ type SessionInfo struct {
 	CreateTime interface{}
 }
 
+// KeyGenFunc generates a new session ID. It is called by Save whenever a
+// session does not already have one. Implementations should return an
+// alphanumeric-safe string suitable for use both as a redis key suffix and
+// as a cookie value.
+type KeyGenFunc func() (string, error)
+
+// DefaultKeyGen is the KeyGenFunc used by new RediStore instances. It
+// generates a 32 byte cryptographically random value, the same scheme
+// RediStore has always used.
+func DefaultKeyGen() (string, error) {
+	return strings.TrimRight(base32.StdEncoding.EncodeToString(securecookie.GenerateRandomKey(32)), "="), nil
+}
+
+// UUIDKeyGen is a KeyGenFunc that generates session IDs as UUIDv4 strings.
+func UUIDKeyGen() (string, error) {
+	id, err := uuid.NewRandom()
+	if err != nil {
+		return "", err
+	}
+	return id.String(), nil
+}
+
+// URLSafeKeyGen returns a KeyGenFunc that generates session IDs as
+// URL-safe, unpadded base64 encodings of n cryptographically random bytes.
+func URLSafeKeyGen(n int) KeyGenFunc {
+	return func() (string, error) {
+		b := securecookie.GenerateRandomKey(n)
+		if b == nil {
+			return "", errors.New("redistore: failed to generate random key")
+		}
+		return base64.RawURLEncoding.EncodeToString(b), nil
+	}
+}
+
 // SetMaxLength sets RediStore.maxLength if the `l` argument is greater or equal 0
 // maxLength restricts the maximum length of new sessions to l.
 // If l is 0 there is no limit to the size of a session, use with caution.
@@ -129,6 +175,26 @@ func (s *RediStore) SetSerializer(ss SessionSerializer) {
 	s.serializer = ss
 }
 
+// SetKeyGen sets the KeyGenFunc used to generate new session IDs. Pass
+// UUIDKeyGen, URLSafeKeyGen(n), or a custom KeyGenFunc to enforce a
+// tenant-prefixed or HMAC-bound ID policy instead of the default.
+func (s *RediStore) SetKeyGen(f KeyGenFunc) {
+	s.KeyGen = f
+}
+
+// SetUserIDKey configures the session.Values key that holds a user
+// identifier. When set, Save maintains a redis SET at
+// "<keyPrefix>user:<userID>" listing that user's session IDs, which
+// SessionsForUser and InvalidateUser use to support "sign out everywhere".
+func (s *RediStore) SetUserIDKey(key string) {
+	s.userIDKey = key
+}
+
+// userSessionsKey returns the redis key of the session-ID set for userID.
+func (s *RediStore) userSessionsKey(userID string) string {
+	return s.keyPrefix + "user:" + userID
+}
+
 // SetMaxAge restricts the maximum age, in seconds, of the session record
 // both in database and a browser. This is to change session storage configuration.
 // If you want just to remove session use your session `s` object and change it's
@@ -152,7 +218,7 @@ func (s *RediStore) SetMaxAge(v int) {
 	}
 }
 
-func dial(network, address, password string,db int) (redis.Cmdable, error) {
+func dial(network, address, password string,db int) (redis.UniversalClient, error) {
 	c:= redis.NewClient(&redis.Options{
 		Network:           network,
 		Addr:               address,
@@ -164,9 +230,9 @@ func dial(network, address, password string,db int) (redis.Cmdable, error) {
 		WriteTimeout:       time.Second*2,
 		PoolSize:           100,
 		MinIdleConns:       1,
-		MaxConnAge:         time.Minute*5,
+		ConnMaxLifetime:    time.Minute*5,
 	})
-	err:=c.Ping().Err()
+	err:=c.Ping(context.Background()).Err()
 	if err!=nil{
 		return nil,err
 	}
@@ -184,7 +250,7 @@ func NewRedisStore(size int, network, address, password string,db int, keyPairs
 	return NewRedisStoreWithPool(pool, keyPairs...),nil
 }
 
-func dialWithDB(network, address, password string,DB int) (redis.Cmdable, error) {
+func dialWithDB(network, address, password string,DB int) (redis.UniversalClient, error) {
 	c, err := dial(network, address, password,DB)
 	if err != nil {
 		return nil, err
@@ -193,7 +259,7 @@ func dialWithDB(network, address, password string,DB int) (redis.Cmdable, error)
 }
 
 // NewRediStoreWithPool instantiates a RediStore with a *redis.Pool passed in.
-func NewRedisStoreWithPool(pool redis.Cmdable, keyPairs ...[]byte) *RediStore {
+func NewRedisStoreWithPool(pool redis.UniversalClient, keyPairs ...[]byte) *RediStore {
 	rs := &RediStore{
 		// http://godoc.org/github.com/gomodule/redigo/redis#Pool
 		Pool:   pool,
@@ -203,16 +269,50 @@ func NewRedisStoreWithPool(pool redis.Cmdable, keyPairs ...[]byte) *RediStore {
 			MaxAge: sessionExpire,
 		},
 		DefaultMaxAge: 60 * 20, // 20 minutes seems like a reasonable default
+		KeyGen:        DefaultKeyGen,
 		maxLength:     4096,
 		keyPrefix:     "session_",
 		serializer:    JSONSerializer{},
+		keyPairs:      keyPairs,
 	}
 	return rs
 }
 
-// Close closes the underlying *redis.Pool
+// NewRedisStoreWithUniversalClient instantiates a RediStore with a
+// redis.UniversalClient passed in. A UniversalClient can be a single-instance
+// *redis.Client, a sentinel-backed *redis.FailoverClient, or a
+// *redis.ClusterClient, letting callers point the store at whichever Redis
+// topology they run without changing any other call sites.
+func NewRedisStoreWithUniversalClient(client redis.UniversalClient, keyPairs ...[]byte) *RediStore {
+	return NewRedisStoreWithPool(client, keyPairs...)
+}
+
+// NewRedisStoreCluster returns a new RediStore backed by a Redis Cluster
+// reachable at addrs. Session keys are looked up and stored one at a time,
+// and the bulk operations that would otherwise UNLINK several keys in one
+// call (DeleteByPattern, InvalidateUser) detect a cluster-backed Pool and
+// fall back to one UNLINK per key, so CROSSSLOT errors do not apply.
+func NewRedisStoreCluster(addrs []string, password string, keyPairs ...[]byte) (*RediStore, error) {
+	c := redis.NewClusterClient(&redis.ClusterOptions{
+		Addrs:           addrs,
+		Password:        password,
+		MaxRetries:      2,
+		DialTimeout:     time.Second * 2,
+		ReadTimeout:     time.Second * 2,
+		WriteTimeout:    time.Second * 2,
+		PoolSize:        100,
+		MinIdleConns:    1,
+		ConnMaxLifetime: time.Minute * 5,
+	})
+	if err := c.Ping(context.Background()).Err(); err != nil {
+		return nil, err
+	}
+	return NewRedisStoreWithUniversalClient(c, keyPairs...), nil
+}
+
+// Close closes the underlying redis.UniversalClient.
 func (s *RediStore) Close() error {
-	return s.Pool.Shutdown().Err()
+	return s.Pool.Close()
 }
 
 // Get returns a session for the given name after adding it to the registry.
@@ -222,10 +322,23 @@ func (s *RediStore) Get(r *http.Request, name string) (*sessions.Session, error)
 	return sessions.GetRegistry(r).Get(s, name)
 }
 
+// GetContext is like Get but runs the underlying redis lookup with ctx,
+// honoring its deadline and cancellation. It does not modify r; the context
+// is attached to a copy.
+func (s *RediStore) GetContext(ctx context.Context, r *http.Request, name string) (*sessions.Session, error) {
+	return s.Get(r.WithContext(ctx), name)
+}
+
 // New returns a session for the given name without adding it to the registry.
 //
 // See gorilla/sessions FilesystemStore.New().
 func (s *RediStore) New(r *http.Request, name string) (*sessions.Session, error) {
+	return s.NewContext(r.Context(), r, name)
+}
+
+// NewContext is like New but honors ctx's deadline and cancellation when
+// talking to redis.
+func (s *RediStore) NewContext(ctx context.Context, r *http.Request, name string) (*sessions.Session, error) {
 	var (
 		err error
 		ok  bool
@@ -240,7 +353,7 @@ func (s *RediStore) New(r *http.Request, name string) (*sessions.Session, error)
 		err = securecookie.DecodeMulti(name, c.Value, sessionInfo, s.Codecs...)
 		if err == nil {
 			session.ID = sessionInfo.ID
-			ok, err = s.load(session)
+			ok, err = s.load(ctx, session)
 			if err==nil && ok {
 				createdTimeV:=session.Values["created_time"]
 				if createdTimeV!=sessionInfo.CreateTime{
@@ -259,23 +372,33 @@ func (s *RediStore) New(r *http.Request, name string) (*sessions.Session, error)
 
 // Save adds a single session to the response.
 func (s *RediStore) Save(r *http.Request, w http.ResponseWriter, session *sessions.Session) error {
+	return s.SaveContext(r.Context(), r, w, session)
+}
+
+// SaveContext is like Save but honors ctx's deadline and cancellation when
+// talking to redis.
+func (s *RediStore) SaveContext(ctx context.Context, r *http.Request, w http.ResponseWriter, session *sessions.Session) error {
 	// Marked for deletion.
 	if session.Options.MaxAge <= 0 {
-		if err := s.delete(session); err != nil {
+		if err := s.delete(ctx, session); err != nil {
 			return err
 		}
 		http.SetCookie(w, sessions.NewCookie(session.Name(), "", session.Options))
 	} else {
 		// Build an alphanumeric key for the redis store.
 		if session.ID == "" {
-			session.ID = strings.TrimRight(base32.StdEncoding.EncodeToString(securecookie.GenerateRandomKey(32)), "=")
+			id, err := s.KeyGen()
+			if err != nil {
+				return err
+			}
+			session.ID = id
 		}
 		createdTime,ok:=session.Values["created_time"]
 		if !ok {
 			createdTime = time.Now().Format("20060102150405")
 			session.Values["created_time"] = createdTime
 		}
-		if err := s.save(session); err != nil {
+		if err := s.save(ctx, session); err != nil {
 			return err
 		}
 		encoded, err := securecookie.EncodeMulti(session.Name(), &SessionInfo{ID:session.ID,CreateTime:createdTime}, s.Codecs...)
@@ -293,8 +416,7 @@ func (s *RediStore) Save(r *http.Request, w http.ResponseWriter, session *sessio
 // WARNING: This method should be considered deprecated since it is not exposed via the gorilla/sessions interface.
 // Set session.Options.MaxAge = -1 and call Save instead. - July 18th, 2013
 func (s *RediStore) Delete(r *http.Request, w http.ResponseWriter, session *sessions.Session) error {
-
-	if  err := s.Pool.Del( s.keyPrefix+session.ID).Err(); err != nil {
+	if err := s.delete(r.Context(), session); err != nil {
 		return err
 	}
 	// Set cookie to expire.
@@ -309,12 +431,16 @@ func (s *RediStore) Delete(r *http.Request, w http.ResponseWriter, session *sess
 }
 
 // save stores the session in redis.
-func (s *RediStore) save(session *sessions.Session) error {
+func (s *RediStore) save(ctx context.Context, session *sessions.Session) error {
 	b, err := s.serializer.Serialize(session)
 	if err != nil {
 		return err
 	}
-	if s.maxLength != 0 && len(b) > s.maxLength {
+	framed, err := s.encodePayload(b)
+	if err != nil {
+		return err
+	}
+	if s.maxLength != 0 && len(framed) > s.maxLength {
 		return errors.New("SessionStore: the value to store is too big")
 	}
 
@@ -322,22 +448,54 @@ func (s *RediStore) save(session *sessions.Session) error {
 	if age == 0 {
 		age = s.DefaultMaxAge
 	}
-	return s.Pool.Set( s.keyPrefix+session.ID, b,time.Duration(age)*time.Second ).Err()
+	if err := s.Pool.Set(ctx, s.keyPrefix+session.ID, framed,time.Duration(age)*time.Second ).Err(); err != nil {
+		return err
+	}
+	if s.userIDKey != "" {
+		if uidv, ok := session.Values[s.userIDKey]; ok {
+			if userID := fmt.Sprint(uidv); userID != "" {
+				key := s.userSessionsKey(userID)
+				if err := s.Pool.SAdd(ctx, key, session.ID).Err(); err != nil {
+					return err
+				}
+				if err := s.Pool.Expire(ctx, key, time.Duration(age)*time.Second).Err(); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
 }
 
 func (s *RediStore)Store(ID string,data map[string]interface{})error  {
+	return s.StoreContext(context.Background(), ID, data)
+}
+
+// StoreContext is like Store but honors ctx's deadline and cancellation when
+// talking to redis.
+func (s *RediStore) StoreContext(ctx context.Context, ID string, data map[string]interface{}) error {
 	b, err := s.serializer.SerializeData(data)
 	if err != nil {
 		return err
 	}
-	if s.maxLength != 0 && len(b) > s.maxLength {
+	framed, err := s.encodePayload(b)
+	if err != nil {
+		return err
+	}
+	if s.maxLength != 0 && len(framed) > s.maxLength {
 		return errors.New("SessionStore: the value to store is too big")
 	}
-	return s.Pool.Set( s.keyPrefix+ID, b,time.Duration(sessionExpire)*time.Second ).Err()
+	return s.Pool.Set(ctx, s.keyPrefix+ID, framed,time.Duration(sessionExpire)*time.Second ).Err()
 }
 
 func (s *RediStore)Load(ID string,data*map[string]interface{})(bool, error)   {
-	d,err:=s.Pool.Get(s.keyPrefix+ID).Bytes()
+	return s.LoadContext(context.Background(), ID, data)
+}
+
+// LoadContext is like Load but honors ctx's deadline and cancellation when
+// talking to redis.
+func (s *RediStore) LoadContext(ctx context.Context, ID string, data *map[string]interface{}) (bool, error) {
+	d,err:=s.Pool.Get(ctx, s.keyPrefix+ID).Bytes()
 	if err != nil {
 		if err ==redis.Nil {
 			return false,nil
@@ -347,13 +505,17 @@ func (s *RediStore)Load(ID string,data*map[string]interface{})(bool, error)   {
 	if data == nil {
 		return false, nil // no data was associated with this key
 	}
+	d, err = s.decodePayload(d)
+	if err != nil {
+		return false, err
+	}
 	return true, s.serializer.DeserializeData(d, data)
 }
 
 // load reads the session from redis.
 // returns true if there is a sessoin data in DB
-func (s *RediStore) load(session *sessions.Session) (bool, error) {
-	data,err:=s.Pool.Get(s.keyPrefix+session.ID).Bytes()
+func (s *RediStore) load(ctx context.Context, session *sessions.Session) (bool, error) {
+	data,err:=s.Pool.Get(ctx, s.keyPrefix+session.ID).Bytes()
 	if err != nil {
 		if err ==redis.Nil {
 			return false,nil
@@ -363,10 +525,207 @@ func (s *RediStore) load(session *sessions.Session) (bool, error) {
 	if data == nil {
 		return false, nil // no data was associated with this key
 	}
+	data, err = s.decodePayload(data)
+	if err != nil {
+		return false, err
+	}
 	return true, s.serializer.Deserialize(data, session)
 }
 
 // delete removes keys from redis if MaxAge<0
-func (s *RediStore) delete(session *sessions.Session) error {
-	return  s.Pool.Del(s.keyPrefix+session.ID).Err()
+func (s *RediStore) delete(ctx context.Context, session *sessions.Session) error {
+	if err := s.Pool.Del(ctx, s.keyPrefix+session.ID).Err(); err != nil {
+		return err
+	}
+	if s.userIDKey != "" {
+		if uidv, ok := session.Values[s.userIDKey]; ok {
+			if userID := fmt.Sprint(uidv); userID != "" {
+				if err := s.Pool.SRem(ctx, s.userSessionsKey(userID), session.ID).Err(); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// LoadSessionByID looks up a session directly by its redis key, bypassing the
+// cookie/registry path used by Get and New. It is meant for callers that have
+// no live *http.Request/http.ResponseWriter, such as background workers,
+// admin tools, gRPC endpoints, or WebSocket upgrades that only know a
+// session ID. IsNew is set to false if the key existed in redis, true
+// otherwise.
+func (s *RediStore) LoadSessionByID(ctx context.Context, sessionID, name string) (*sessions.Session, error) {
+	session := sessions.NewSession(s, name)
+	options := *s.Options
+	session.Options = &options
+	session.ID = sessionID
+	ok, err := s.load(ctx, session)
+	if err != nil {
+		return nil, err
+	}
+	session.IsNew = !ok
+	return session, nil
+}
+
+// SaveSessionByID stores sess under sessionID, bypassing the cookie/registry
+// path used by Save. It reuses the configured serializer, key prefix, and
+// MaxAge semantics, so a session saved this way can later be read back by
+// Get/New once a cookie referencing sessionID is issued.
+//
+// Like SaveContext, a sess.Options.MaxAge <= 0 means "delete": the session is
+// removed from redis instead of being stored.
+func (s *RediStore) SaveSessionByID(ctx context.Context, sessionID string, sess *sessions.Session) error {
+	sess.ID = sessionID
+	if sess.Options == nil {
+		options := *s.Options
+		sess.Options = &options
+	}
+	if sess.Options.MaxAge <= 0 {
+		return s.delete(ctx, sess)
+	}
+	if _, ok := sess.Values["created_time"]; !ok {
+		sess.Values["created_time"] = time.Now().Format("20060102150405")
+	}
+	return s.save(ctx, sess)
+}
+
+// scanKeys iterates every key matching pattern on a single node (via SCAN,
+// never KEYS), invoking fn with each page of keys.
+func scanKeys(ctx context.Context, node redis.UniversalClient, pattern string, count int64, fn func(keys []string) error) error {
+	var cursor uint64
+	for {
+		keys, next, err := node.Scan(ctx, cursor, pattern, count).Result()
+		if err != nil {
+			return err
+		}
+		if len(keys) > 0 {
+			if err := fn(keys); err != nil {
+				return err
+			}
+		}
+		cursor = next
+		if cursor == 0 {
+			return nil
+		}
+	}
+}
+
+// forEachNode calls fn once per node that can hold keys: once for s.Pool
+// itself for a single-instance or sentinel-backed store, or once per master
+// for a *redis.ClusterClient. SCAN only ever enumerates the keyspace of the
+// single node it is sent to, so a plain Scan against a ClusterClient would
+// silently cover just one shard; fanning out over every master is required
+// to see the whole keyspace.
+func (s *RediStore) forEachNode(ctx context.Context, fn func(ctx context.Context, node redis.UniversalClient) error) error {
+	if cluster, ok := s.Pool.(*redis.ClusterClient); ok {
+		return cluster.ForEachMaster(ctx, func(ctx context.Context, client *redis.Client) error {
+			return fn(ctx, client)
+		})
+	}
+	return fn(ctx, s.Pool)
+}
+
+// ListSessionIDs returns the IDs of sessions whose key matches match (a
+// SCAN-style glob pattern against the portion of the key after keyPrefix; use
+// "*" to list every session). It iterates with SCAN rather than KEYS so it is
+// safe to call against a live, populated redis instance, count is a hint
+// passed through to SCAN's COUNT option, and against a cluster-backed store
+// it scans every master so the whole keyspace is covered.
+func (s *RediStore) ListSessionIDs(ctx context.Context, match string, count int64) ([]string, error) {
+	if match == "" {
+		match = "*"
+	}
+	var mu sync.Mutex
+	var ids []string
+	err := s.forEachNode(ctx, func(ctx context.Context, node redis.UniversalClient) error {
+		return scanKeys(ctx, node, s.keyPrefix+match, count, func(keys []string) error {
+			mu.Lock()
+			for _, k := range keys {
+				ids = append(ids, strings.TrimPrefix(k, s.keyPrefix))
+			}
+			mu.Unlock()
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// DeleteByID removes the session with the given ID from redis, using UNLINK
+// so the reclaim happens asynchronously on the redis side.
+func (s *RediStore) DeleteByID(ctx context.Context, id string) error {
+	return s.Pool.Unlink(ctx, s.keyPrefix+id).Err()
+}
+
+// unlinkKeys removes keys via UNLINK, returning the number removed. Against
+// a *redis.ClusterClient a single multi-key UNLINK would be rejected with
+// CROSSSLOT unless every key happened to hash to the same slot, which
+// randomly generated session keys in general do not, so in that case each key
+// is unlinked with its own call instead of being batched into one.
+func (s *RediStore) unlinkKeys(ctx context.Context, node redis.UniversalClient, keys []string) (int64, error) {
+	if len(keys) == 0 {
+		return 0, nil
+	}
+	if _, ok := s.Pool.(*redis.ClusterClient); ok {
+		var deleted int64
+		for _, k := range keys {
+			n, err := node.Unlink(ctx, k).Result()
+			deleted += n
+			if err != nil {
+				return deleted, err
+			}
+		}
+		return deleted, nil
+	}
+	return node.Unlink(ctx, keys...).Result()
+}
+
+// DeleteByPattern deletes every session whose key matches pattern (a
+// SCAN-style glob pattern against the portion of the key after keyPrefix),
+// returning the number of keys removed. Like ListSessionIDs it iterates with
+// SCAN, batches deletes per SCAN page via UNLINK, and against a
+// cluster-backed store scans every master so the whole keyspace is covered.
+func (s *RediStore) DeleteByPattern(ctx context.Context, pattern string) (int, error) {
+	if pattern == "" {
+		pattern = "*"
+	}
+	var deleted int64
+	err := s.forEachNode(ctx, func(ctx context.Context, node redis.UniversalClient) error {
+		return scanKeys(ctx, node, s.keyPrefix+pattern, 100, func(keys []string) error {
+			n, err := s.unlinkKeys(ctx, node, keys)
+			atomic.AddInt64(&deleted, n)
+			return err
+		})
+	})
+	return int(deleted), err
+}
+
+// SessionsForUser returns the IDs of the sessions currently indexed for
+// userID. It requires SetUserIDKey to have been configured and populated by
+// Save; otherwise the index is empty.
+func (s *RediStore) SessionsForUser(ctx context.Context, userID string) ([]string, error) {
+	return s.Pool.SMembers(ctx, s.userSessionsKey(userID)).Result()
+}
+
+// InvalidateUser logs userID out of every session Save has indexed for them,
+// UNLINKing each session key plus the index itself, and returns the number of
+// sessions invalidated.
+func (s *RediStore) InvalidateUser(ctx context.Context, userID string) (int, error) {
+	indexKey := s.userSessionsKey(userID)
+	ids, err := s.Pool.SMembers(ctx, indexKey).Result()
+	if err != nil {
+		return 0, err
+	}
+	keys := make([]string, 0, len(ids)+1)
+	for _, id := range ids {
+		keys = append(keys, s.keyPrefix+id)
+	}
+	keys = append(keys, indexKey)
+	if _, err := s.unlinkKeys(ctx, s.Pool, keys); err != nil {
+		return 0, err
+	}
+	return len(ids), nil
 }
@@ -0,0 +1,21 @@
+package redistore
+
+import "testing"
+
+func TestUserSessionsKey(t *testing.T) {
+	s := &RediStore{keyPrefix: "session_"}
+	got := s.userSessionsKey("alice")
+	want := "session_user:alice"
+	if got != want {
+		t.Errorf("userSessionsKey(%q) = %q, want %q", "alice", got, want)
+	}
+}
+
+func TestUserSessionsKeyWithEmptyPrefix(t *testing.T) {
+	s := &RediStore{}
+	got := s.userSessionsKey("alice")
+	want := "user:alice"
+	if got != want {
+		t.Errorf("userSessionsKey(%q) = %q, want %q", "alice", got, want)
+	}
+}
@@ -0,0 +1,96 @@
+package redistore
+
+import (
+	"context"
+	"testing"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// fakeScanClient is a redis.UniversalClient that only implements Scan,
+// paging through pages on each call. Every other method panics if called,
+// which is fine since scanKeys/forEachNode never touch them.
+type fakeScanClient struct {
+	redis.UniversalClient
+	pages [][]string
+}
+
+func (f *fakeScanClient) Scan(ctx context.Context, cursor uint64, match string, count int64) *redis.ScanCmd {
+	if cursor >= uint64(len(f.pages)) {
+		return redis.NewScanCmdResult(nil, 0, nil)
+	}
+	next := cursor + 1
+	if next >= uint64(len(f.pages)) {
+		next = 0
+	}
+	return redis.NewScanCmdResult(f.pages[cursor], next, nil)
+}
+
+func TestScanKeysIteratesEveryPage(t *testing.T) {
+	client := &fakeScanClient{pages: [][]string{
+		{"a", "b"},
+		{"c"},
+		{"d", "e"},
+	}}
+
+	var got []string
+	err := scanKeys(context.Background(), client, "*", 10, func(keys []string) error {
+		got = append(got, keys...)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("scanKeys: %v", err)
+	}
+
+	want := []string{"a", "b", "c", "d", "e"}
+	if len(got) != len(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("got %v, want %v", got, want)
+			break
+		}
+	}
+}
+
+func TestScanKeysStopsOnCallbackError(t *testing.T) {
+	client := &fakeScanClient{pages: [][]string{
+		{"a"},
+		{"b"},
+	}}
+
+	wantErr := errStub("boom")
+	calls := 0
+	err := scanKeys(context.Background(), client, "*", 10, func(keys []string) error {
+		calls++
+		return wantErr
+	})
+	if err != wantErr {
+		t.Fatalf("expected %v, got %v", wantErr, err)
+	}
+	if calls != 1 {
+		t.Errorf("expected scanKeys to stop after the first page, got %d calls", calls)
+	}
+}
+
+func TestForEachNodeUsesPoolDirectlyWhenNotCluster(t *testing.T) {
+	client := &fakeScanClient{pages: [][]string{{"a"}}}
+	s := &RediStore{Pool: client}
+
+	var seen redis.UniversalClient
+	err := s.forEachNode(context.Background(), func(ctx context.Context, node redis.UniversalClient) error {
+		seen = node
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("forEachNode: %v", err)
+	}
+	if seen != client {
+		t.Error("expected forEachNode to invoke fn with s.Pool for a non-cluster store")
+	}
+}
+
+type errStub string
+
+func (e errStub) Error() string { return string(e) }